@@ -0,0 +1,201 @@
+// +build linux
+
+package manager
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/pkg/errors"
+)
+
+// ioctlFiclone is `FICLONE` from `<linux/fs.h>` - it asks the
+// filesystem to make the destination file descriptor share the
+// source's blocks via copy-on-write, instead of copying them.
+const ioctlFiclone = 0x40049409
+
+// CloneFallback controls what Clone does when a file can't be
+// reflinked - either because the filesystem doesn't support it
+// (`EOPNOTSUPP`) or because src/dst don't live on the same
+// filesystem (`EXDEV`).
+type CloneFallback int
+
+const (
+	// CloneFallbackNone makes Clone fail instead of falling
+	// back to copying.
+	CloneFallbackNone CloneFallback = iota
+
+	// CloneFallbackCopy makes Clone fall back to
+	// `copy_file_range(2)`, and then to a plain userspace copy
+	// if that's unavailable too, whenever reflinking isn't
+	// possible.
+	CloneFallbackCopy
+)
+
+// Clone creates dst as a new volume bound by its own project quota
+// (capped at size bytes), populated by reflinking every regular
+// file out of the already existing volume src.
+//
+// Since reflinked blocks are shared between src and dst until one
+// side writes to them, this lets callers cheaply fork a base volume
+// while keeping both independently bounded by their own quota.
+func (m *Manager) Clone(src, dst string, size uint64, fallback CloneFallback) (absPath string, err error) {
+	srcVol, found, err := m.Get(src)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve source volume %s", src)
+		return
+	}
+
+	if !found {
+		err = errors.Errorf("source volume %s not found", src)
+		return
+	}
+
+	absPath, err = m.Create(Volume{Name: dst, Size: size})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create destination volume %s", dst)
+		return
+	}
+
+	err = cloneTree(srcVol.Path, absPath, fallback)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to populate volume %s from %s", dst, src)
+		return
+	}
+
+	return
+}
+
+// cloneTree walks srcRoot, recreating its directory structure under
+// dstRoot and reflinking (or copying) every regular file it finds.
+func cloneTree(srcRoot, dstRoot string, fallback CloneFallback) (err error) {
+	entries, err := ioutil.ReadDir(srcRoot)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to list directory %s", srcRoot)
+		return
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(srcRoot, entry.Name())
+		dstPath := filepath.Join(dstRoot, entry.Name())
+
+		if entry.IsDir() {
+			err = os.MkdirAll(dstPath, entry.Mode())
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create directory %s", dstPath)
+				return
+			}
+
+			err = cloneTree(srcPath, dstPath, fallback)
+			if err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		err = cloneFile(srcPath, dstPath, entry.Mode(), fallback)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// cloneFile reflinks srcPath onto dstPath via `FICLONE`, falling
+// back to `copy_file_range(2)` and then to a plain userspace copy
+// when fallback is CloneFallbackCopy and reflinking isn't possible.
+func cloneFile(srcPath, dstPath string, mode os.FileMode, fallback CloneFallback) (err error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open source file %s", srcPath)
+		return
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create destination file %s", dstPath)
+		return
+	}
+	defer dstFile.Close()
+
+	errno := doFiclone(dstFile.Fd(), srcFile.Fd())
+	if errno == 0 {
+		return
+	}
+
+	if errno != unix.EXDEV && errno != unix.EOPNOTSUPP {
+		err = errors.Wrapf(errno, "FICLONE failed for %s -> %s", srcPath, dstPath)
+		return
+	}
+
+	if fallback != CloneFallbackCopy {
+		err = errors.Errorf(
+			"reflink not supported between %s and %s (%s) and no fallback requested - "+
+				"pass CloneFallbackCopy/--fallback=copy to fall back to a regular copy",
+			srcPath, dstPath, errno)
+		return
+	}
+
+	err = copyFile(srcFile, dstFile)
+	if err != nil {
+		err = errors.Wrapf(err, "fallback copy failed for %s -> %s", srcPath, dstPath)
+		return
+	}
+
+	return
+}
+
+// doFiclone issues the `FICLONE` ioctl on dstFd, sourcing its blocks
+// from srcFd, returning the raw `errno` (0 on success) so callers
+// can distinguish `EXDEV`/`EOPNOTSUPP` from a hard failure.
+func doFiclone(dstFd, srcFd uintptr) unix.Errno {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, dstFd, uintptr(ioctlFiclone), srcFd)
+	return errno
+}
+
+// copyFile falls back to `copy_file_range(2)` and, failing that, to
+// a plain userspace copy via `io.Copy`.
+func copyFile(src, dst *os.File) (err error) {
+	info, err := src.Stat()
+	if err != nil {
+		err = errors.Wrapf(err, "failed to stat source file")
+		return
+	}
+
+	remaining := info.Size()
+	for remaining > 0 {
+		n, cerr := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(remaining), 0)
+		if cerr != nil {
+			break
+		}
+		if n == 0 {
+			break
+		}
+		remaining -= int64(n)
+	}
+
+	if remaining == 0 {
+		return
+	}
+
+	// copy_file_range advances both fds' offsets as it goes, so
+	// whatever is left to copy picks up right where it stopped.
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		err = errors.Wrapf(err, "plain copy fallback failed")
+		return
+	}
+
+	return
+}
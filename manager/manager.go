@@ -0,0 +1,217 @@
+// +build linux
+
+// Package manager lays out volumes as directories under a single
+// root and bounds their growth via the project-quota primitives
+// exposed by the `xfs` package.
+package manager
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/auto1-oss/xfsvol/xfs"
+	"github.com/pkg/errors"
+)
+
+// Config specifies how a Manager should be initialized.
+type Config struct {
+	// Root is the directory under which all the volumes
+	// managed get created. It must live on a filesystem that
+	// supports project quotas (XFS, or ext4 mounted with
+	// `prjquota`).
+	Root string
+
+	// Filesystem overrides the filesystem auto-detected for
+	// Root. Leave it unset to have it detected via `statfs(2)`.
+	Filesystem xfs.Filesystem
+
+	// StartingProjectId specifies the minimum project id that
+	// should be used when allocating project ids under Root.
+	StartingProjectId *uint32
+}
+
+// Manager creates, lists, retrieves and destroys volumes rooted at
+// a single directory, each bound by its own project quota.
+type Manager struct {
+	root    string
+	control xfs.Control
+}
+
+// New initializes a Manager, creating cfg.Root if necessary and
+// setting up project quota tracking for it.
+func New(cfg Config) (m Manager, err error) {
+	if cfg.Root == "" {
+		err = errors.Errorf("Root must be specified")
+		return
+	}
+
+	err = os.MkdirAll(cfg.Root, 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create root directory %s", cfg.Root)
+		return
+	}
+
+	m.root = cfg.Root
+	m.control, err = xfs.NewControl(xfs.ControlConfig{
+		BasePath:          cfg.Root,
+		Filesystem:        cfg.Filesystem,
+		StartingProjectId: cfg.StartingProjectId,
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to initialize quota control under %s", cfg.Root)
+		return
+	}
+
+	return
+}
+
+// Close closes the Manager's quota control. It holds no state file
+// lock between calls (the lock is only taken for each read-modify-
+// write of the state file), so this currently releases nothing.
+func (m *Manager) Close() (err error) {
+	return m.control.Close()
+}
+
+// GetFilesystem reports which filesystem (xfs or ext4) backs this
+// Manager's root - mostly useful for `xfsvolctl ls` to surface to
+// the operator.
+func (m *Manager) GetFilesystem() (fs xfs.Filesystem) {
+	fs = m.control.GetFilesystem()
+	return
+}
+
+// GetProjectIdStats reports the last project id handed out and how
+// many paths currently have one assigned - mostly useful for
+// exporting id-exhaustion metrics.
+func (m *Manager) GetProjectIdStats() (last uint32, count int) {
+	last = m.control.GetLastProjectId()
+	count = m.control.GetProjectIdCount()
+	return
+}
+
+// Create materializes vol as a directory under the Manager's root
+// and assigns it a project quota matching vol.Size/vol.INode,
+// returning the absolute path created.
+func (m *Manager) Create(vol Volume) (absPath string, err error) {
+	if vol.Name == "" {
+		err = errors.Errorf("volume Name must be specified")
+		return
+	}
+
+	absPath = filepath.Join(m.root, vol.Name)
+
+	err = os.MkdirAll(absPath, 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create volume directory %s", absPath)
+		return
+	}
+
+	err = m.control.SetQuota(absPath, xfs.Quota{
+		Size:      vol.Size,
+		SizeSoft:  vol.SizeSoft,
+		INode:     vol.INode,
+		INodeSoft: vol.INodeSoft,
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to set quota for volume %s", vol.Name)
+		return
+	}
+
+	return
+}
+
+// List enumerates the volumes currently living under the Manager's
+// root. A directory that can't be resolved to a volume (e.g. it has
+// no project id yet, because Create crashed between MkdirAll and
+// SetQuota, or it's an unrelated directory an operator dropped under
+// Root) is logged and skipped rather than failing the whole listing.
+func (m *Manager) List() (vols []Volume, err error) {
+	entries, err := ioutil.ReadDir(m.root)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to list directories under root %s", m.root)
+		return
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		vol, found, getErr := m.get(entry.Name())
+		if getErr != nil {
+			log.Printf("name: %s, error: %s, skipping unresolvable volume directory", entry.Name(), getErr)
+			continue
+		}
+
+		if !found {
+			continue
+		}
+
+		vols = append(vols, vol)
+	}
+
+	return
+}
+
+// Get retrieves a single volume by name.
+func (m *Manager) Get(name string) (vol Volume, found bool, err error) {
+	return m.get(name)
+}
+
+func (m *Manager) get(name string) (vol Volume, found bool, err error) {
+	absPath := filepath.Join(m.root, name)
+
+	if _, statErr := os.Stat(absPath); os.IsNotExist(statErr) {
+		return
+	} else if statErr != nil {
+		err = errors.Wrapf(statErr, "failed to stat volume directory %s", absPath)
+		return
+	}
+
+	found = true
+	vol.Name = name
+	vol.Path = absPath
+
+	quota, err := m.control.GetQuota(absPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to retrieve quota for volume %s", name)
+		return
+	}
+
+	vol.Size = quota.Size
+	vol.SizeSoft = quota.SizeSoft
+	vol.INode = quota.INode
+	vol.INodeSoft = quota.INodeSoft
+	vol.Status = map[string]interface{}{
+		"size_bytes_hard": quota.Size,
+		"size_bytes_used": quota.SizeUsed,
+		"inodes_hard":     quota.INode,
+		"inodes_used":     quota.INodeUsed,
+	}
+	return
+}
+
+// Delete removes the volume directory named name and releases the
+// project id that was bound to it, so a future volume created under
+// the same name starts out with a fresh project id (and therefore a
+// fresh quota) rather than inheriting the stale one.
+func (m *Manager) Delete(name string) (err error) {
+	absPath := filepath.Join(m.root, name)
+
+	err = os.RemoveAll(absPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to remove volume directory %s", absPath)
+		return
+	}
+
+	err = m.control.ReleaseProjectId(absPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to release project id for volume %s", name)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sizeUnits maps the single-letter suffixes accepted in `size`
+// volume options to the power-of-1024 they represent.
+var sizeUnits = map[string]uint64{
+	"":  1,
+	"b": 1,
+	"k": 1024,
+	"m": 1024 * 1024,
+	"g": 1024 * 1024 * 1024,
+	"t": 1024 * 1024 * 1024 * 1024,
+}
+
+// FromHumanSize parses a human-readable size, such as "512M" or
+// "10G", into the number of bytes it represents.
+func FromHumanSize(size string) (bytes uint64, err error) {
+	size = strings.TrimSpace(size)
+	if size == "" {
+		err = errors.Errorf("size must not be empty")
+		return
+	}
+
+	suffix := strings.ToLower(size[len(size)-1:])
+	numberPart := size
+
+	unit, ok := sizeUnits[suffix]
+	if ok && suffix != "" {
+		numberPart = size[:len(size)-1]
+	} else {
+		unit = 1
+	}
+
+	value, err := strconv.ParseFloat(numberPart, 64)
+	if err != nil {
+		err = errors.Wrapf(err, "couldn't parse numeric part of size %s", size)
+		return
+	}
+
+	bytes = uint64(value * float64(unit))
+	return
+}
+
+// HumanSize renders a number of bytes using the largest unit
+// ("K", "M", "G", "T") that keeps the value at or above 1.
+func HumanSize(bytes uint64) (size string) {
+	units := []string{"T", "G", "M", "K"}
+	thresholds := []uint64{
+		sizeUnits["t"], sizeUnits["g"], sizeUnits["m"], sizeUnits["k"],
+	}
+
+	for idx, threshold := range thresholds {
+		if bytes >= threshold {
+			size = fmt.Sprintf("%.0f%s", float64(bytes)/float64(threshold), units[idx])
+			return
+		}
+	}
+
+	size = fmt.Sprintf("%dB", bytes)
+	return
+}
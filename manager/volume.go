@@ -0,0 +1,35 @@
+package manager
+
+// Volume represents a directory, living under a Manager's root,
+// whose growth is bound by a project quota.
+type Volume struct {
+	// Name is the volume's name, relative to the Manager's
+	// root.
+	Name string
+
+	// Path is the absolute path to the volume's directory.
+	Path string
+
+	// Size is the hard limit, in bytes, enforced for the
+	// volume.
+	Size uint64
+
+	// SizeSoft is the soft byte limit enforced for the volume.
+	// Zero means "same as Size".
+	SizeSoft uint64
+
+	// INode is the hard limit on the number of inodes
+	// enforced for the volume.
+	INode uint32
+
+	// INodeSoft is the soft inode limit enforced for the
+	// volume. Zero means "same as INode".
+	INodeSoft uint32
+
+	// Status carries the live quota usage for the volume, as
+	// populated by Manager.Get/List:
+	// "size_bytes_hard", "size_bytes_used", "inodes_hard",
+	// "inodes_used". It mirrors the shape handed back to
+	// `docker volume inspect` as the volume's Status field.
+	Status map[string]interface{}
+}
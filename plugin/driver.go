@@ -2,9 +2,16 @@ package main
 
 import (
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/cirocosta/xfsvol/manager"
+	"golang.org/x/sys/unix"
+
+	"github.com/auto1-oss/xfsvol/manager"
+	"github.com/auto1-oss/xfsvol/xfs"
 	"github.com/pkg/errors"
 
 	v "github.com/docker/go-plugins-helpers/volume"
@@ -13,15 +20,31 @@ import (
 type DriverConfig struct {
 	HostMountpoint string
 	DefaultSize    string
+
+	// Filesystem overrides the filesystem auto-detected for
+	// HostMountpoint ("xfs" or "ext4"). Leave empty to have it
+	// detected via `statfs(2)`.
+	Filesystem string
+
+	// MountRoot is where per-container bind mounts get created.
+	// Defaults to defaultMountRoot.
+	MountRoot string
 }
 
 type Driver struct {
 	defaultSize string
+	mountRoot   string
 	manager     *manager.Manager
+	mounts      map[string]*mountState
+	metrics     *metrics
 	sync.Mutex
 }
 
-func NewDriver(cfg DriverConfig) (d Driver, err error) {
+// NewDriver returns a *Driver (rather than a Driver) so that the
+// single sync.Mutex it embeds is shared by every method call and by
+// the metrics handler - a value receiver would hand each call its own
+// copy of the Mutex, defeating the locking entirely.
+func NewDriver(cfg DriverConfig) (d *Driver, err error) {
 	if cfg.HostMountpoint == "" {
 		err = errors.Errorf("HostMountpoint must be specified")
 		return
@@ -32,8 +55,15 @@ func NewDriver(cfg DriverConfig) (d Driver, err error) {
 		return
 	}
 
+	fs, err := xfs.ParseFilesystem(cfg.Filesystem)
+	if err != nil {
+		err = errors.Wrapf(err, "invalid Filesystem override %q", cfg.Filesystem)
+		return
+	}
+
 	m, err := manager.New(manager.Config{
-		Root: cfg.HostMountpoint,
+		Root:       cfg.HostMountpoint,
+		Filesystem: fs,
 	})
 	if err != nil {
 		err = errors.Wrapf(err,
@@ -42,14 +72,36 @@ func NewDriver(cfg DriverConfig) (d Driver, err error) {
 		return
 	}
 
+	d = &Driver{}
+
+	d.mountRoot = cfg.MountRoot
+	if d.mountRoot == "" {
+		d.mountRoot = defaultMountRoot
+	}
+
+	err = os.MkdirAll(d.mountRoot, 0755)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to create mount root %s", d.mountRoot)
+		return
+	}
+
+	d.mounts, err = loadMountState(d.mountRoot)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to load mount state from %s", d.mountRoot)
+		return
+	}
+
 	d.defaultSize = cfg.DefaultSize
 	log.Println("driver initiated")
 	d.manager = &m
+	d.metrics = newMetrics(d)
 
 	return
 }
 
-func (d Driver) Create(req *v.CreateRequest) (err error) {
+func (d *Driver) Create(req *v.CreateRequest) (err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("create", err, time.Since(start)) }()
 
 	size, present := req.Options["size"]
 	if !present {
@@ -65,27 +117,91 @@ func (d Driver) Create(req *v.CreateRequest) (err error) {
 		return
 	}
 
+	var sizeSoftInBytes uint64
+	if sizeSoft, present := req.Options["size_soft"]; present {
+		sizeSoftInBytes, err = manager.FromHumanSize(sizeSoft)
+		if err != nil {
+			err = errors.Errorf(
+				"couldn't convert specified size_soft [%s] into bytes",
+				sizeSoft)
+			return
+		}
+	}
+
+	var inodes uint64
+	if inodesOpt, present := req.Options["inodes"]; present {
+		inodes, err = strconv.ParseUint(inodesOpt, 10, 32)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"couldn't convert specified inodes [%s] into a number",
+				inodesOpt)
+			return
+		}
+	}
+
+	var inodeSoft uint64
+	if inodeSoftOpt, present := req.Options["inode_soft"]; present {
+		inodeSoft, err = strconv.ParseUint(inodeSoftOpt, 10, 32)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"couldn't convert specified inode_soft [%s] into a number",
+				inodeSoftOpt)
+			return
+		}
+	}
+
+	from, cloning := req.Options["from"]
+
+	cloneFallback := manager.CloneFallbackNone
+	if fallback, present := req.Options["fallback"]; present {
+		switch fallback {
+		case "":
+			cloneFallback = manager.CloneFallbackNone
+		case "copy":
+			cloneFallback = manager.CloneFallbackCopy
+		default:
+			err = errors.Errorf("unknown fallback option %q, expected '' or 'copy'", fallback)
+			return
+		}
+	}
+
 	d.Lock()
 	defer d.Unlock()
 
 	log.Printf("%s starting creation", req.Name)
 
-	absHostPath, err := d.manager.Create(manager.Volume{
-		Name: req.Name,
-		Size: sizeInBytes,
-	})
-	if err != nil {
-		err = errors.Wrapf(err,
-			"manager failed to create volume %s",
-			req.Name)
-		return
+	var absHostPath string
+	if cloning {
+		absHostPath, err = d.manager.Clone(from, req.Name, sizeInBytes, cloneFallback)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"manager failed to clone volume %s from %s",
+				req.Name, from)
+			return
+		}
+	} else {
+		absHostPath, err = d.manager.Create(manager.Volume{
+			Name:      req.Name,
+			Size:      sizeInBytes,
+			SizeSoft:  sizeSoftInBytes,
+			INode:     uint32(inodes),
+			INodeSoft: uint32(inodeSoft),
+		})
+		if err != nil {
+			err = errors.Wrapf(err,
+				"manager failed to create volume %s",
+				req.Name)
+			return
+		}
 	}
 
 	log.Printf("abs-host-path: %s finished creating volume", absHostPath)
 	return
 }
 
-func (d Driver) List() (resp *v.ListResponse, err error) {
+func (d *Driver) List() (resp *v.ListResponse, err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("list", err, time.Since(start)) }()
 
 	d.Lock()
 	defer d.Unlock()
@@ -103,7 +219,8 @@ func (d Driver) List() (resp *v.ListResponse, err error) {
 	resp.Volumes = make([]*v.Volume, len(vols))
 	for idx, vol := range vols {
 		resp.Volumes[idx] = &v.Volume{
-			Name: vol.Name,
+			Name:   vol.Name,
+			Status: vol.Status,
 		}
 	}
 
@@ -111,7 +228,9 @@ func (d Driver) List() (resp *v.ListResponse, err error) {
 	return
 }
 
-func (d Driver) Get(req *v.GetRequest) (resp *v.GetResponse, err error) {
+func (d *Driver) Get(req *v.GetRequest) (resp *v.GetResponse, err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("get", err, time.Since(start)) }()
 
 	d.Lock()
 	defer d.Unlock()
@@ -133,13 +252,16 @@ func (d Driver) Get(req *v.GetRequest) (resp *v.GetResponse, err error) {
 	resp.Volume = &v.Volume{
 		Name:       req.Name,
 		Mountpoint: vol.Path,
+		Status:     vol.Status,
 	}
 
 	log.Printf("finished retrieving volume %s", req.Name)
 	return
 }
 
-func (d Driver) Remove(req *v.RemoveRequest) (err error) {
+func (d *Driver) Remove(req *v.RemoveRequest) (err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("remove", err, time.Since(start)) }()
 
 	d.Lock()
 	defer d.Unlock()
@@ -155,7 +277,9 @@ func (d Driver) Remove(req *v.RemoveRequest) (err error) {
 	return
 }
 
-func (d Driver) Path(req *v.PathRequest) (resp *v.PathResponse, err error) {
+func (d *Driver) Path(req *v.PathRequest) (resp *v.PathResponse, err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("path", err, time.Since(start)) }()
 
 	d.Lock()
 	defer d.Unlock()
@@ -179,7 +303,9 @@ func (d Driver) Path(req *v.PathRequest) (resp *v.PathResponse, err error) {
 	return
 }
 
-func (d Driver) Mount(req *v.MountRequest) (resp *v.MountResponse, err error) {
+func (d *Driver) Mount(req *v.MountRequest) (resp *v.MountResponse, err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("mount", err, time.Since(start)) }()
 
 	d.Lock()
 	defer d.Unlock()
@@ -197,28 +323,101 @@ func (d Driver) Mount(req *v.MountRequest) (resp *v.MountResponse, err error) {
 		return
 	}
 
-	log.Printf("finished mounting volume %s", req.Name)
+	st, ok := d.mounts[req.Name]
+	if !ok {
+		st = &mountState{Mountpoints: make(map[string]string)}
+		d.mounts[req.Name] = st
+	}
+
+	mountpoint, alreadyMounted := st.Mountpoints[req.ID]
+	if !alreadyMounted {
+		mountpoint = filepath.Join(d.mountRoot, req.Name, req.ID)
+
+		err = os.MkdirAll(mountpoint, 0755)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to create mountpoint %s", mountpoint)
+			return
+		}
+
+		err = unix.Mount(vol.Path, mountpoint, "", unix.MS_BIND, "")
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to bind-mount %s onto %s", vol.Path, mountpoint)
+			return
+		}
+
+		st.Mountpoints[req.ID] = mountpoint
+		st.Refs++
+
+		err = saveMountState(d.mountRoot, d.mounts)
+		if err != nil {
+			return
+		}
+	}
+
+	log.Printf("id: %s, refs: %d, finished mounting volume %s at %s",
+		req.ID, st.Refs, req.Name, mountpoint)
 
 	resp = new(v.MountResponse)
-	resp.Mountpoint = vol.Path
+	resp.Mountpoint = mountpoint
 	return
 }
 
-func (d Driver) Unmount(req *v.UnmountRequest) (err error) {
+func (d *Driver) Unmount(req *v.UnmountRequest) (err error) {
+	start := time.Now()
+	defer func() { d.metrics.recordRequest("unmount", err, time.Since(start)) }()
 
 	d.Lock()
 	defer d.Unlock()
 
-	log.Printf("finished unmounting %s", req.Name)
+	st, ok := d.mounts[req.Name]
+	if !ok {
+		err = errors.Errorf("volume %s is not mounted", req.Name)
+		return
+	}
+
+	mountpoint, ok := st.Mountpoints[req.ID]
+	if !ok {
+		err = errors.Errorf("id %s is not mounted for volume %s", req.ID, req.Name)
+		return
+	}
+
+	err = unix.Unmount(mountpoint, 0)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to unmount %s", mountpoint)
+		return
+	}
+
+	err = os.Remove(mountpoint)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to remove mountpoint %s", mountpoint)
+		return
+	}
+
+	delete(st.Mountpoints, req.ID)
+	st.Refs--
+
+	if st.Refs <= 0 {
+		delete(d.mounts, req.Name)
+		_ = os.Remove(filepath.Join(d.mountRoot, req.Name))
+	}
+
+	err = saveMountState(d.mountRoot, d.mounts)
+	if err != nil {
+		return
+	}
 
+	log.Printf("id: %s, refs: %d, finished unmounting %s", req.ID, st.Refs, req.Name)
 	return
 }
 
-// TODO is it global?
-func (d Driver) Capabilities() (resp *v.CapabilitiesResponse) {
+// Capabilities reports this driver as "local": quotas and the
+// backing device are host-local, so a volume created on one Docker
+// swarm node is not implicitly usable on another.
+func (d *Driver) Capabilities() (resp *v.CapabilitiesResponse) {
 	resp = &v.CapabilitiesResponse{
 		Capabilities: v.Capability{
-			Scope: "global",
+			Scope: "local",
 		},
 	}
 	return
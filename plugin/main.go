@@ -15,6 +15,9 @@ const (
 type config struct {
 	HostMountpoint string `arg:"--host-mountpoint,env:HOST_MOUNTPOINT,help:xfs-mounted filesystem to create volumes"`
 	DefaultSize    string `arg:"--default-size,env:DEFAULT_SIZE,help:default size to use as quota"`
+	Filesystem     string `arg:"--filesystem,env:FILESYSTEM,help:filesystem backing host-mountpoint ('xfs' or 'ext4') - auto-detected when unset"`
+	MountRoot      string `arg:"--mount-root,env:MOUNT_ROOT,help:where per-container bind mounts are created"`
+	MetricsAddr    string `arg:"--metrics-addr,env:METRICS_ADDR,help:address to serve prometheus /metrics on (disabled when unset)"`
 	Debug          bool   `arg:"env:DEBUG,help:enable debug logs"`
 }
 
@@ -35,11 +38,21 @@ func main() {
 	d, err := NewDriver(DriverConfig{
 		HostMountpoint: args.HostMountpoint,
 		DefaultSize:    args.DefaultSize,
+		Filesystem:     args.Filesystem,
+		MountRoot:      args.MountRoot,
 	})
 	if err != nil {
 		log.Fatalf("%s failed to initialize XFS volume driver", err)
 	}
 
+	if args.MetricsAddr != "" {
+		go func() {
+			if err := d.metrics.Serve(args.MetricsAddr); err != nil {
+				log.Fatalf("%s failed to serve prometheus metrics on %s", err, args.MetricsAddr)
+			}
+		}()
+	}
+
 	h := v.NewHandler(d)
 	err = h.ServeUnix(socketAddress, 0)
 	if err != nil {
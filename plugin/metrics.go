@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the histogram bucket boundaries, in seconds,
+// used for xfsvol_driver_request_duration_seconds.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// durationHistogram accumulates observations for a single driver
+// operation.
+type durationHistogram struct {
+	counts []uint64 // cumulative per-bucket counts, parallel to durationBuckets
+	sum    float64
+	count  uint64
+}
+
+// metrics collects the counters/histograms exposed at /metrics in
+// Prometheus text exposition format, plus the volume/project-id
+// gauges read live off the manager on every scrape.
+type metrics struct {
+	sync.Mutex
+
+	driver *Driver
+
+	requestTotal    map[[2]string]uint64          // [op,status] -> count
+	requestDuration map[string]*durationHistogram // op -> histogram
+}
+
+func newMetrics(d *Driver) *metrics {
+	return &metrics{
+		driver:          d,
+		requestTotal:    make(map[[2]string]uint64),
+		requestDuration: make(map[string]*durationHistogram),
+	}
+}
+
+// recordRequest is called, via defer, by every Driver method to
+// track how many times it's been called, with what outcome, and how
+// long it took.
+func (m *metrics) recordRequest(op string, err error, dur time.Duration) {
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.requestTotal[[2]string{op, status}]++
+
+	h, ok := m.requestDuration[op]
+	if !ok {
+		h = &durationHistogram{counts: make([]uint64, len(durationBuckets))}
+		m.requestDuration[op] = h
+	}
+
+	seconds := dur.Seconds()
+	h.sum += seconds
+	h.count++
+	for i, bucket := range durationBuckets {
+		if seconds <= bucket {
+			h.counts[i]++
+		}
+	}
+}
+
+// ServeHTTP renders the current state of every metric in Prometheus
+// text exposition format.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	requestTotal := make(map[[2]string]uint64, len(m.requestTotal))
+	for k, v := range m.requestTotal {
+		requestTotal[k] = v
+	}
+	requestDuration := make(map[string]durationHistogram, len(m.requestDuration))
+	for k, v := range m.requestDuration {
+		cp := *v
+		cp.counts = append([]uint64(nil), v.counts...)
+		requestDuration[k] = cp
+	}
+	m.Unlock()
+
+	m.driver.Lock()
+	vols, err := m.driver.manager.List()
+	lastProjectId, projectIdCount := m.driver.manager.GetProjectIdStats()
+	m.driver.Unlock()
+	if err != nil {
+		log.Printf("metrics: failed to list volumes: %s", err)
+	}
+
+	fmt.Fprintln(w, "# HELP xfsvol_volume_size_bytes Project quota size, in bytes, by limit kind.")
+	fmt.Fprintln(w, "# TYPE xfsvol_volume_size_bytes gauge")
+	for _, vol := range vols {
+		fmt.Fprintf(w, "xfsvol_volume_size_bytes{name=%q,limit=\"hard\"} %d\n", vol.Name, vol.Size)
+		fmt.Fprintf(w, "xfsvol_volume_size_bytes{name=%q,limit=\"used\"} %d\n", vol.Name, vol.Status["size_bytes_used"])
+	}
+
+	fmt.Fprintln(w, "# HELP xfsvol_volume_inodes Project quota inode count, by limit kind.")
+	fmt.Fprintln(w, "# TYPE xfsvol_volume_inodes gauge")
+	for _, vol := range vols {
+		fmt.Fprintf(w, "xfsvol_volume_inodes{name=%q,limit=\"hard\"} %d\n", vol.Name, vol.INode)
+		fmt.Fprintf(w, "xfsvol_volume_inodes{name=%q,limit=\"used\"} %d\n", vol.Name, vol.Status["inodes_used"])
+	}
+
+	fmt.Fprintln(w, "# HELP xfsvol_projectid_last Last project id handed out.")
+	fmt.Fprintln(w, "# TYPE xfsvol_projectid_last gauge")
+	fmt.Fprintf(w, "xfsvol_projectid_last %d\n", lastProjectId)
+
+	fmt.Fprintln(w, "# HELP xfsvol_projectid_count Number of paths with a project id currently assigned.")
+	fmt.Fprintln(w, "# TYPE xfsvol_projectid_count gauge")
+	fmt.Fprintf(w, "xfsvol_projectid_count %d\n", projectIdCount)
+
+	fmt.Fprintln(w, "# HELP xfsvol_driver_requests_total Total driver operations, by operation and outcome.")
+	fmt.Fprintln(w, "# TYPE xfsvol_driver_requests_total counter")
+	for k, v := range requestTotal {
+		fmt.Fprintf(w, "xfsvol_driver_requests_total{op=%q,status=%q} %d\n", k[0], k[1], v)
+	}
+
+	fmt.Fprintln(w, "# HELP xfsvol_driver_request_duration_seconds Driver operation latency, by operation.")
+	fmt.Fprintln(w, "# TYPE xfsvol_driver_request_duration_seconds histogram")
+	ops := make([]string, 0, len(requestDuration))
+	for op := range requestDuration {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := requestDuration[op]
+		// h.counts[i] is already the cumulative count for bucket i -
+		// recordRequest increments every bucket an observation falls
+		// into, not just the first one it satisfies - so it's printed
+		// as-is rather than summed again here.
+		for i, bucket := range durationBuckets {
+			fmt.Fprintf(w, "xfsvol_driver_request_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, bucket, h.counts[i])
+		}
+		fmt.Fprintf(w, "xfsvol_driver_request_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.count)
+		fmt.Fprintf(w, "xfsvol_driver_request_duration_seconds_sum{op=%q} %g\n", op, h.sum)
+		fmt.Fprintf(w, "xfsvol_driver_request_duration_seconds_count{op=%q} %d\n", op, h.count)
+	}
+}
+
+// Serve starts an HTTP server exposing /metrics at addr. It blocks
+// for the lifetime of the process, so callers run it in a goroutine.
+func (m *metrics) Serve(addr string) (err error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+
+	log.Printf("metrics-addr: %s serving prometheus metrics", addr)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMountRoot is where per-container bind mounts for a volume
+// get created, namespaced by volume name and then by the docker-
+// assigned mount ID (`req.ID` on `Driver.Mount`/`Driver.Unmount`).
+const defaultMountRoot = "/var/lib/xfsvol/mounts"
+
+// mountStateFileName is where the ref-counted mount table gets
+// persisted so a plugin restart can pick back up instead of leaking
+// the bind mounts it made before going down.
+const mountStateFileName = ".mounts-state.db"
+
+// mountState tracks, for a single volume, how many containers
+// currently have it mounted and where each of their bind mounts
+// lives.
+type mountState struct {
+	Refs        int               `json:"refs"`
+	Mountpoints map[string]string `json:"mountpoints"` // docker mount ID -> bind-mount path
+}
+
+// loadMountState reads back the persisted ref-counted mount table
+// from mountRoot, dropping any entry whose mountpoint isn't actually
+// there anymore (e.g. the host rebooted and the bind mounts are
+// gone) so the plugin doesn't believe it owns mounts it doesn't.
+func loadMountState(mountRoot string) (states map[string]*mountState, err error) {
+	states = make(map[string]*mountState)
+
+	raw, err := ioutil.ReadFile(filepath.Join(mountRoot, mountStateFileName))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read mount state file under %s", mountRoot)
+		return
+	}
+
+	err = json.Unmarshal(raw, &states)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse mount state file under %s", mountRoot)
+		return
+	}
+
+	for name, st := range states {
+		for id, mountpoint := range st.Mountpoints {
+			if !isMounted(mountpoint) {
+				log.Printf("volume %s id %s mountpoint %s no longer mounted, dropping", name, id, mountpoint)
+				delete(st.Mountpoints, id)
+				st.Refs--
+			}
+		}
+
+		if st.Refs <= 0 {
+			delete(states, name)
+		}
+	}
+
+	return
+}
+
+// saveMountState persists states to mountRoot.
+func saveMountState(mountRoot string, states map[string]*mountState) (err error) {
+	raw, err := json.Marshal(states)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to serialize mount state")
+		return
+	}
+
+	finalPath := filepath.Join(mountRoot, mountStateFileName)
+	tmpPath := finalPath + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, raw, 0600)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write temporary mount state file %s", tmpPath)
+		return
+	}
+
+	err = os.Rename(tmpPath, finalPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to replace mount state file %s", finalPath)
+		return
+	}
+
+	return
+}
+
+// isMounted reports whether path is itself a mountpoint, by
+// comparing its device id against its parent's.
+func isMounted(path string) bool {
+	var pathStat, parentStat unix.Stat_t
+
+	if err := unix.Stat(path, &pathStat); err != nil {
+		return false
+	}
+
+	if err := unix.Stat(filepath.Dir(path), &parentStat); err != nil {
+		return false
+	}
+
+	return pathStat.Dev != parentStat.Dev
+}
@@ -21,11 +21,24 @@ const blockDeviceName = "__control-device"
 // Control gives the context to be used by storage driver
 // who wants to apply project quotas to container dirs.
 type Control struct {
+	// basePath is the root of the project quota tree this
+	// Control manages - the same value ControlConfig.BasePath
+	// was set to.
+	basePath string
+
 	// backingFsBlockDev is the absolute path to the
 	// block device that keeps track of quotas under
 	// a given basePath (root of the project quota tree).
 	backingFsBlockDev string
 
+	// filesystem is the kind of filesystem backing BasePath,
+	// as detected (or overridden) at NewControl time. Besides
+	// NewControl rejecting anything other than xfs or ext4, it's
+	// only used for reporting purposes - XFS and ext4 share
+	// the same project-quota ioctls (see the `xfs` package's
+	// ioctl_linux.go).
+	filesystem Filesystem
+
 	// projectIdCache keeps track of the relation between
 	// directories and project-ids.
 	//
@@ -55,6 +68,11 @@ type ControlConfig struct {
 	// Right in `BasePath` is also where a block device
 	// is put to keep track of the quotas.
 	BasePath string
+
+	// Filesystem overrides the filesystem auto-detected (via
+	// `statfs(2)`) for BasePath. Leave it at its zero value
+	// (`FilesystemUnknown`) to let NewControl detect it.
+	Filesystem Filesystem
 }
 
 // NewControl initializes project quota support under a given
@@ -70,10 +88,31 @@ func NewControl(cfg ControlConfig) (c Control, err error) {
 		return
 	}
 
+	c.basePath = cfg.BasePath
+
 	if cfg.StartingProjectId != nil {
 		c.lastProjectId = *cfg.StartingProjectId
 	}
 
+	c.filesystem = cfg.Filesystem
+	if c.filesystem == FilesystemUnknown {
+		c.filesystem, err = DetectFilesystem(cfg.BasePath)
+		if err != nil {
+			err = errors.Wrapf(err,
+				"failed to detect filesystem backing base path %s",
+				cfg.BasePath)
+			return
+		}
+	}
+
+	if c.filesystem != FilesystemXFS && c.filesystem != FilesystemExt4 {
+		err = errors.Errorf(
+			"base path %s is backed by an unsupported filesystem - "+
+				"only xfs and ext4 (mounted with 'prjquota') are supported",
+			cfg.BasePath)
+		return
+	}
+
 	err = MakeBackingFsDev(cfg.BasePath, blockDeviceName)
 	if err != nil {
 		err = errors.Wrapf(err,
@@ -84,7 +123,15 @@ func NewControl(cfg ControlConfig) (c Control, err error) {
 
 	c.backingFsBlockDev = filepath.Join(cfg.BasePath, blockDeviceName)
 
-	c.projectIdCache, err = GeneratePathToProjectIdMap(cfg.BasePath)
+	err = probePrjQuota(c.backingFsBlockDev)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"prjquota probe failed for base path %s (filesystem: %s)",
+			cfg.BasePath, c.filesystem)
+		return
+	}
+
+	onDisk, err := GeneratePathToProjectIdMap(cfg.BasePath)
 	if err != nil {
 		err = errors.Wrapf(err,
 			"failed to create projectid cache from basepath %s",
@@ -92,14 +139,103 @@ func NewControl(cfg ControlConfig) (c Control, err error) {
 		return
 	}
 
-	for _, projectId := range c.projectIdCache {
-		if projectId > c.lastProjectId {
-			c.lastProjectId = projectId
+	var st state
+	err = withStateLock(cfg.BasePath, func() (lockedErr error) {
+		st, lockedErr = loadState(cfg.BasePath)
+		if lockedErr != nil {
+			return
 		}
+
+		reconcileState(&st, onDisk)
+		lockedErr = saveState(cfg.BasePath, st)
+		return
+	})
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to load/reconcile persisted state from basepath %s",
+			cfg.BasePath)
+		return
+	}
+
+	c.projectIdCache = st.Paths
+	if st.NextProjectId > c.lastProjectId {
+		c.lastProjectId = st.NextProjectId
+	}
+
+	log.Printf("base-path: %s, filesystem: %s, last-project-id: %s, new control created",
+		cfg.BasePath, c.filesystem, c.lastProjectId)
+
+	return
+}
+
+// Close is a no-op kept for API symmetry (and for callers that
+// already defer it): the state file lock is no longer held for the
+// Control's lifetime (see withStateLock), only for the duration of
+// each read-modify-write, so there's nothing left to release here.
+func (c *Control) Close() (err error) {
+	return
+}
+
+// persistState writes the in-memory projectIdCache/lastProjectId
+// back to the state file backing this Control, under the state
+// file's lock.
+func (c *Control) persistState() (err error) {
+	err = withStateLock(c.basePath, func() error {
+		return saveState(c.basePath, state{
+			Paths:         c.projectIdCache,
+			NextProjectId: c.lastProjectId,
+		})
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "failed to persist state for basepath %s", c.basePath)
+		return
+	}
+
+	return
+}
+
+// ReserveProjectId assigns a project id to targetPath (allocating a
+// new one, persisted to the state file, if targetPath doesn't have
+// one yet) without touching its quota.
+func (c *Control) ReserveProjectId(targetPath string) (projectId uint32, err error) {
+	projectId, ok := c.projectIdCache[targetPath]
+	if ok {
+		return
+	}
+
+	projectId = c.lastProjectId + 1
+
+	err = SetProjectId(targetPath, projectId)
+	if err != nil {
+		err = errors.Wrapf(err, "couldn't set project id on path %s", targetPath)
+		return
+	}
+
+	c.projectIdCache[targetPath] = projectId
+	c.lastProjectId = projectId
+
+	err = c.persistState()
+	if err != nil {
+		return
 	}
 
-	log.Printf("base-path: %s, last-project-id: %s, new control created", cfg.BasePath, c.lastProjectId)
+	log.Printf("project-id: %d, target-path: %s reserved", projectId, targetPath)
+	return
+}
+
+// ReleaseProjectId forgets the project id assigned to targetPath.
+// It does not reclaim the numeric id itself - ids are handed out
+// monotonically, never reused - it only drops the bookkeeping entry
+// so the path is no longer reported as quota-managed.
+func (c *Control) ReleaseProjectId(targetPath string) (err error) {
+	delete(c.projectIdCache, targetPath)
 
+	err = c.persistState()
+	if err != nil {
+		return
+	}
+
+	log.Printf("target-path: %s released", targetPath)
 	return
 }
 
@@ -110,6 +246,26 @@ func (c *Control) GetBackingFsBlockDev() (blockDev string) {
 	return
 }
 
+// GetFilesystem retrieves the filesystem (xfs or ext4) backing the
+// BasePath this Control was set up with.
+func (c *Control) GetFilesystem() (fs Filesystem) {
+	fs = c.filesystem
+	return
+}
+
+// GetLastProjectId reports the most recently allocated project id.
+func (c *Control) GetLastProjectId() (id uint32) {
+	id = c.lastProjectId
+	return
+}
+
+// GetProjectIdCount reports how many paths currently have a project
+// id assigned.
+func (c *Control) GetProjectIdCount() (count int) {
+	count = len(c.projectIdCache)
+	return
+}
+
 // GetQuota retrieves the quota settings associated with a targetPath
 // that previously had a quota set for it.
 //
@@ -139,22 +295,14 @@ func (c *Control) GetQuota(targetPath string) (q *Quota, err error) {
 func (c *Control) SetQuota(targetPath string, quota Quota) (err error) {
 	log.Printf("cache: %s will set quota", c.projectIdCache)
 
-	projectId, ok := c.projectIdCache[targetPath]
-	if !ok {
-		projectId = c.lastProjectId + 1
-		err = SetProjectId(targetPath, projectId)
-		if err != nil {
-			err = errors.Wrapf(err,
-				"couldn't set project id to path %s",
-				targetPath)
-			return
-		}
-
-		c.projectIdCache[targetPath] = projectId
-		c.lastProjectId = projectId
-
-		log.Printf("project-id: %s setting new project id", projectId)
+	projectId, err := c.ReserveProjectId(targetPath)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"couldn't reserve project id for path %s",
+			targetPath)
+		return
 	}
+
 	log.Printf("project-id: %s, last-project-id: %s, target-path: %s, quota-size: %s, quota-inode: %s setting quota", projectId, c.lastProjectId, targetPath, quota.Size, quota.INode)
 
 	err = SetProjectQuota(c.backingFsBlockDev, projectId, &quota)
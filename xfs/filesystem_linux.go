@@ -0,0 +1,91 @@
+// +build linux
+
+package xfs
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/pkg/errors"
+)
+
+// Filesystem identifies which on-disk filesystem backs a given
+// BasePath, and therefore which project-quota code path
+// Control should drive.
+type Filesystem int
+
+const (
+	// FilesystemUnknown means that `statfs` reported a magic
+	// number that this package does not know how to drive
+	// project quotas on.
+	FilesystemUnknown Filesystem = iota
+
+	// FilesystemXFS is set when BasePath lives on an XFS
+	// filesystem.
+	FilesystemXFS
+
+	// FilesystemExt4 is set when BasePath lives on an ext4
+	// filesystem with the `prjquota` mount option enabled.
+	FilesystemExt4
+)
+
+// magic numbers as reported by `statfs(2)` in `f_type` - see
+// `/usr/include/linux/magic.h`.
+const (
+	xfsSuperMagic  = 0x58465342
+	ext4SuperMagic = 0xEF53
+)
+
+// String renders the human-readable name used throughout logs and
+// `xfsvolctl ls` output.
+func (f Filesystem) String() string {
+	switch f {
+	case FilesystemXFS:
+		return "xfs"
+	case FilesystemExt4:
+		return "ext4"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseFilesystem converts the textual name of a filesystem (as
+// accepted by the `--filesystem`/`Filesystem` config knobs) into a
+// Filesystem value. An empty name maps to FilesystemUnknown, which
+// callers should treat as "auto-detect".
+func ParseFilesystem(name string) (fs Filesystem, err error) {
+	switch name {
+	case "":
+		fs = FilesystemUnknown
+	case "xfs":
+		fs = FilesystemXFS
+	case "ext4":
+		fs = FilesystemExt4
+	default:
+		err = errors.Errorf("unknown filesystem %q, expected 'xfs' or 'ext4'", name)
+	}
+
+	return
+}
+
+// DetectFilesystem figures out which filesystem backs `path` by
+// inspecting the magic number that `statfs(2)` reports for it.
+func DetectFilesystem(path string) (fs Filesystem, err error) {
+	var buf unix.Statfs_t
+
+	err = unix.Statfs(path, &buf)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to statfs path %s", path)
+		return
+	}
+
+	switch uint32(buf.Type) {
+	case xfsSuperMagic:
+		fs = FilesystemXFS
+	case ext4SuperMagic:
+		fs = FilesystemExt4
+	default:
+		fs = FilesystemUnknown
+	}
+
+	return
+}
@@ -0,0 +1,298 @@
+// +build linux
+
+package xfs
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/pkg/errors"
+)
+
+// ioctl / quotactl opcodes.
+//
+// `FS_IOC_FSGETXATTR`/`FS_IOC_FSSETXATTR` are the generic,
+// filesystem-agnostic ioctls (added to the VFS so that ext4, btrfs
+// and XFS can all expose the same project-id attribute) that
+// superseded the XFS-only `XFS_IOC_FSGETXATTR`/`XFS_IOC_FSSETXATTR`
+// pair. Both encode the same `fsxattr` layout, so either can be used
+// to read/write the project id of a directory.
+const (
+	fsIocFsGetXattr = 0x801c581f
+	fsIocFsSetXattr = 0x401c5820
+)
+
+// quotactl(2) subcommands, as defined in `<linux/dqblk_xfs.h>` via
+// `XQM_CMD(n) = (('X'<<8)+(n))`. Applied against `PRJQUOTA` (by
+// QCMDing them with prjQuota below), these are understood by both
+// the XFS and the ext4 quota code, as long as the filesystem was
+// mounted with `prjquota`.
+const (
+	qXGetPQuota = 0x5803 // XQM_CMD(3), Q_XGETQUOTA
+	qXSetPQLim  = 0x5804 // XQM_CMD(4), Q_XSETQLIM
+	qXGetQStat  = 0x5805 // XQM_CMD(5), Q_XGETQSTAT
+
+	prjQuota = 2 // PRJQUOTA, from <sys/quota.h>
+)
+
+// fsXattr mirrors `struct fsxattr` from `<linux/fs.h>`: the subset of
+// fields this package cares about (the project id) plus the padding
+// required to keep the ioctl buffer the right size.
+type fsXattr struct {
+	fsXflags     uint32
+	fsExtSize    uint32
+	fsNExtents   uint32
+	fsProjId     uint32
+	fsCowExtSize uint32
+	fsPad        [8]byte
+}
+
+// fsXflagProjInherit is `FS_XFLAG_PROJINHERIT` from `<linux/fs.h>`:
+// set on a directory, it makes entries created under it inherit its
+// project id.
+const fsXflagProjInherit = 0x00000200
+
+// fsDiskQuota mirrors `struct fs_disk_quota` from
+// `<linux/dqblk_xfs.h>`: field-for-field, in the kernel's own order,
+// since quotactl(2) reads/writes this buffer by raw offset - getting
+// the order wrong silently points this package's "inode" fields at
+// the kernel's block-count/usage fields instead (see ioctl_linux_test.go,
+// which pins Sizeof and the offsets that matter).
+type fsDiskQuota struct {
+	dVersion      int8
+	dFlags        int8
+	dFieldMask    uint16
+	dId           uint32
+	dBlkHardLimit uint64
+	dBlkSoftLimit uint64
+	dInoHardLimit uint64
+	dInoSoftLimit uint64
+	dBCount       uint64
+	dICount       uint64
+	dItimer       int32
+	dBTimer       int32
+	dIWarns       uint16
+	dBWarns       uint16
+	dPadding1     [4]byte // d_{i,b,rtb}timer_hi + d_padding2 on kernels with FS_DQ_BIGTIME support
+	dRtbHardLimit uint64
+	dRtbSoftLimit uint64
+	dRtbCount     uint64
+	dRtbTimer     int32
+	dRtbWarns     uint16
+	dPadding2     int16
+	dPadding3     [8]byte
+}
+
+// Field-mask bits for fsDiskQuota.dFieldMask, as defined in
+// `<linux/dqblk_xfs.h>` (FS_DQ_*) - which limits a Q_XSETQLIM call
+// actually applies depends on which of these are set.
+const (
+	fieldMaskISoft = 0x00000001 // FS_DQ_ISOFT
+	fieldMaskIHard = 0x00000002 // FS_DQ_IHARD
+	fieldMaskBSoft = 0x00000004 // FS_DQ_BSOFT
+	fieldMaskBHard = 0x00000008 // FS_DQ_BHARD
+)
+
+// MakeBackingFsDev creates, under basePath, a special character
+// device file that points back at the device the basePath is
+// mounted from. `quotactl(2)` needs this device node (rather than a
+// regular path) as its target.
+func MakeBackingFsDev(basePath string, deviceName string) (err error) {
+	var stat unix.Stat_t
+
+	err = unix.Stat(basePath, &stat)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to stat base path %s", basePath)
+		return
+	}
+
+	devicePath := basePath + string(os.PathSeparator) + deviceName
+	_ = os.Remove(devicePath)
+
+	err = unix.Mknod(devicePath, unix.S_IFCHR|0600, int(stat.Dev))
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to create backing fs device %s", devicePath)
+		return
+	}
+
+	return
+}
+
+// GetProjectId retrieves the project id currently assigned to
+// targetPath, via the generic `FS_IOC_FSGETXATTR` ioctl. A project
+// id of 0 means "none assigned".
+func GetProjectId(targetPath string) (projectId uint32, err error) {
+	f, err := os.Open(targetPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open path %s", targetPath)
+		return
+	}
+	defer f.Close()
+
+	var xattr fsXattr
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(),
+		uintptr(fsIocFsGetXattr), uintptr(unsafe.Pointer(&xattr)))
+	if errno != 0 {
+		err = errors.Wrapf(errno,
+			"FS_IOC_FSGETXATTR failed for path %s", targetPath)
+		return
+	}
+
+	projectId = xattr.fsProjId
+	return
+}
+
+// SetProjectId assigns projectId to targetPath, via the generic
+// `FS_IOC_FSSETXATTR` ioctl, and marks it with the project-inherit
+// flag so that everything created under it gets the same project id.
+func SetProjectId(targetPath string, projectId uint32) (err error) {
+	f, err := os.Open(targetPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open path %s", targetPath)
+		return
+	}
+	defer f.Close()
+
+	var xattr fsXattr
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(),
+		uintptr(fsIocFsGetXattr), uintptr(unsafe.Pointer(&xattr)))
+	if errno != 0 {
+		err = errors.Wrapf(errno,
+			"FS_IOC_FSGETXATTR failed for path %s", targetPath)
+		return
+	}
+
+	xattr.fsProjId = projectId
+	xattr.fsXflags |= fsXflagProjInherit
+
+	_, _, errno = unix.Syscall(unix.SYS_IOCTL, f.Fd(),
+		uintptr(fsIocFsSetXattr), uintptr(unsafe.Pointer(&xattr)))
+	if errno != 0 {
+		err = errors.Wrapf(errno,
+			"FS_IOC_FSSETXATTR failed for path %s", targetPath)
+		return
+	}
+
+	return
+}
+
+// SetProjectQuota sets, for projectId, the block and inode hard and
+// soft limits carried by quota, against the backing filesystem device
+// backingFsBlockDev.
+//
+// This relies on `Q_XSETPQLIM`, which both XFS and ext4 (mounted
+// with `prjquota`) answer to - there is no XFS-only path left.
+func SetProjectQuota(backingFsBlockDev string, projectId uint32, quota *Quota) (err error) {
+	var d fsDiskQuota
+	d.dVersion = 1
+	d.dId = projectId
+	d.dFlags = prjQuota
+
+	if quota.Size > 0 {
+		d.dBlkHardLimit = quota.Size / 512
+		d.dBlkSoftLimit = d.dBlkHardLimit
+		if quota.SizeSoft > 0 {
+			d.dBlkSoftLimit = quota.SizeSoft / 512
+		}
+		d.dFieldMask |= fieldMaskBHard | fieldMaskBSoft
+	}
+
+	if quota.INode > 0 {
+		d.dInoHardLimit = uint64(quota.INode)
+		d.dInoSoftLimit = d.dInoHardLimit
+		if quota.INodeSoft > 0 {
+			d.dInoSoftLimit = uint64(quota.INodeSoft)
+		}
+		d.dFieldMask |= fieldMaskIHard | fieldMaskISoft
+	}
+
+	cs, err := unix.BytePtrFromString(backingFsBlockDev)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to convert backing device path %s", backingFsBlockDev)
+		return
+	}
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL,
+		uintptr(qXSetPQLim<<8|prjQuota),
+		uintptr(unsafe.Pointer(cs)),
+		uintptr(projectId),
+		uintptr(unsafe.Pointer(&d)), 0, 0)
+	if errno != 0 {
+		err = errors.Wrapf(errno,
+			"Q_XSETPQLIM failed for project-id %d on device %s",
+			projectId, backingFsBlockDev)
+		return
+	}
+
+	return
+}
+
+// GetProjectQuota reads back, for projectId, the limits and current
+// usage tracked by the backing filesystem device backingFsBlockDev.
+func GetProjectQuota(backingFsBlockDev string, projectId uint32) (q *Quota, err error) {
+	var d fsDiskQuota
+
+	cs, err := unix.BytePtrFromString(backingFsBlockDev)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to convert backing device path %s", backingFsBlockDev)
+		return
+	}
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL,
+		uintptr(qXGetPQuota<<8|prjQuota),
+		uintptr(unsafe.Pointer(cs)),
+		uintptr(projectId),
+		uintptr(unsafe.Pointer(&d)), 0, 0)
+	if errno != 0 {
+		err = errors.Wrapf(errno,
+			"Q_XGETPQUOTA failed for project-id %d on device %s",
+			projectId, backingFsBlockDev)
+		return
+	}
+
+	q = &Quota{
+		Size:      d.dBlkHardLimit * 512,
+		SizeSoft:  d.dBlkSoftLimit * 512,
+		INode:     uint32(d.dInoHardLimit),
+		INodeSoft: uint32(d.dInoSoftLimit),
+		SizeUsed:  d.dBCount * 512,
+		INodeUsed: uint32(d.dICount),
+	}
+	return
+}
+
+// probePrjQuota checks that the `prjquota` accounting is actually
+// active on backingFsBlockDev by issuing a harmless `Q_XGETQSTAT`
+// call against it - if project quota accounting was never turned on
+// for the mount, this fails.
+func probePrjQuota(backingFsBlockDev string) (err error) {
+	var d fsDiskQuota
+
+	cs, err := unix.BytePtrFromString(backingFsBlockDev)
+	if err != nil {
+		err = errors.Wrapf(err,
+			"failed to convert backing device path %s", backingFsBlockDev)
+		return
+	}
+
+	_, _, errno := unix.Syscall6(unix.SYS_QUOTACTL,
+		uintptr(qXGetQStat<<8|prjQuota),
+		uintptr(unsafe.Pointer(cs)),
+		0,
+		uintptr(unsafe.Pointer(&d)), 0, 0)
+	if errno != 0 && errno != syscall.ENOSYS {
+		err = errors.Wrapf(errno,
+			"prjquota accounting does not appear to be active on %s - "+
+				"is the filesystem mounted with the 'prjquota' option?",
+			backingFsBlockDev)
+		return
+	}
+
+	return
+}
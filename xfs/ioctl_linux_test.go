@@ -0,0 +1,40 @@
+// +build linux
+
+package xfs
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// TestFsDiskQuotaLayout pins fsDiskQuota's size and the offsets of the
+// fields quotactl(2) actually reads/writes limits and usage through,
+// against `struct fs_disk_quota` from `<linux/dqblk_xfs.h>`. Getting
+// the field order wrong here doesn't fail loudly - it silently points
+// these fields at the wrong bytes of the kernel's buffer (e.g. inode
+// limits landing on the block-count/usage fields instead).
+func TestFsDiskQuotaLayout(t *testing.T) {
+	var d fsDiskQuota
+
+	if got, want := unsafe.Sizeof(d), uintptr(112); got != want {
+		t.Fatalf("unsafe.Sizeof(fsDiskQuota{}) = %d, want %d", got, want)
+	}
+
+	offsets := map[string]struct {
+		got  uintptr
+		want uintptr
+	}{
+		"dBlkHardLimit": {unsafe.Offsetof(d.dBlkHardLimit), 8},
+		"dBlkSoftLimit": {unsafe.Offsetof(d.dBlkSoftLimit), 16},
+		"dInoHardLimit": {unsafe.Offsetof(d.dInoHardLimit), 24},
+		"dInoSoftLimit": {unsafe.Offsetof(d.dInoSoftLimit), 32},
+		"dBCount":       {unsafe.Offsetof(d.dBCount), 40},
+		"dICount":       {unsafe.Offsetof(d.dICount), 48},
+	}
+
+	for name, off := range offsets {
+		if off.got != off.want {
+			t.Errorf("unsafe.Offsetof(fsDiskQuota{}.%s) = %d, want %d", name, off.got, off.want)
+		}
+	}
+}
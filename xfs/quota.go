@@ -0,0 +1,37 @@
+// +build linux
+
+package xfs
+
+// Quota represents the limits to be enforced by a project quota
+// assigned to a given directory, plus - when returned by
+// GetProjectQuota - the current usage against those limits.
+//
+// A zero value for either limit field means "no limit" for that
+// particular dimension.
+type Quota struct {
+	// Size is the hard limit, in bytes, that a project is
+	// allowed to grow up to.
+	Size uint64
+
+	// SizeSoft is the soft limit, in bytes - the project is
+	// allowed to exceed it for a grace period before the hard
+	// limit kicks in. Zero means "same as Size".
+	SizeSoft uint64
+
+	// INode is the hard limit on the number of inodes
+	// (files, directories, ...) that a project is allowed
+	// to allocate.
+	INode uint32
+
+	// INodeSoft is the soft inode limit. Zero means "same as
+	// INode".
+	INodeSoft uint32
+
+	// SizeUsed is the number of bytes currently consumed by the
+	// project - only populated by GetProjectQuota.
+	SizeUsed uint64
+
+	// INodeUsed is the number of inodes currently allocated by
+	// the project - only populated by GetProjectQuota.
+	INodeUsed uint32
+}
@@ -0,0 +1,144 @@
+// +build linux
+
+package xfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/pkg/errors"
+)
+
+// stateFileName is where Control persists the path -> project-id
+// mapping and the next project id to hand out, so that restarts (of
+// the plugin, or of `xfsvolctl` running alongside it) keep the id
+// counter and don't race with one another. NewControl still scans
+// every directory under BasePath on each start and reconciles the
+// file against what it finds (see reconcileState).
+const stateFileName = ".xfsvol-state.db"
+
+// lockFileName backs the `flock(2)` that serializes access to
+// stateFileName across processes sharing the same BasePath.
+const lockFileName = ".xfsvol-state.lock"
+
+// state is the on-disk representation of stateFileName.
+type state struct {
+	Paths         map[string]uint32 `json:"paths"`
+	NextProjectId uint32            `json:"next_project_id"`
+}
+
+// withStateLock runs fn with an exclusive flock held on
+// BasePath/lockFileName, releasing it (by closing the fd) once fn
+// returns - regardless of whether it's loading, reconciling or
+// persisting the state file.
+//
+// The lock is scoped to a single read-modify-write, not to a
+// Control's lifetime: holding it any longer would mean the plugin
+// process - which keeps a Control around for as long as it runs -
+// would permanently starve `xfsvolctl` (or any other process)
+// calling NewControl against the same BasePath.
+func withStateLock(basePath string, fn func() error) (err error) {
+	lockFile, err := os.OpenFile(
+		filepath.Join(basePath, lockFileName),
+		os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to open state lock file under %s", basePath)
+		return
+	}
+	defer lockFile.Close()
+
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to acquire lock on state file under %s", basePath)
+		return
+	}
+	defer unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+
+	err = fn()
+	return
+}
+
+// loadState reads back BasePath/stateFileName, returning a
+// zero-value state if it doesn't exist yet. The caller must already
+// hold the lock acquired by withStateLock.
+func loadState(basePath string) (s state, err error) {
+	s.Paths = make(map[string]uint32)
+
+	raw, err := ioutil.ReadFile(filepath.Join(basePath, stateFileName))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read state file under %s", basePath)
+		return
+	}
+
+	err = json.Unmarshal(raw, &s)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to parse state file under %s", basePath)
+		return
+	}
+
+	if s.Paths == nil {
+		s.Paths = make(map[string]uint32)
+	}
+
+	return
+}
+
+// saveState atomically (write-then-rename) persists s to
+// BasePath/stateFileName. The caller must already hold the lock
+// acquired by withStateLock.
+func saveState(basePath string, s state) (err error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to serialize state")
+		return
+	}
+
+	finalPath := filepath.Join(basePath, stateFileName)
+	tmpPath := finalPath + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, raw, 0600)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to write temporary state file %s", tmpPath)
+		return
+	}
+
+	err = os.Rename(tmpPath, finalPath)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to replace state file %s", finalPath)
+		return
+	}
+
+	return
+}
+
+// reconcileState merges the authoritative, ioctl-derived mapping
+// (onDisk) into the persisted state read back from stateFileName:
+// entries recorded in the state file for paths that no longer carry
+// that project id are evicted, and directories found on disk but
+// missing from the state file are imported.
+func reconcileState(s *state, onDisk map[string]uint32) {
+	for path, projectId := range s.Paths {
+		actual, ok := onDisk[path]
+		if !ok || actual != projectId {
+			delete(s.Paths, path)
+		}
+	}
+
+	for path, projectId := range onDisk {
+		if _, ok := s.Paths[path]; !ok {
+			s.Paths[path] = projectId
+		}
+
+		if projectId > s.NextProjectId {
+			s.NextProjectId = projectId
+		}
+	}
+}
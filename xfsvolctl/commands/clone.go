@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/auto1-oss/xfsvol/manager"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var Clone = cli.Command{
+	Name:  "clone",
+	Usage: "Creates a new volume populated from an existing one via reflink",
+	Description: `Creates a new volume and populates it by reflinking
+   (FICLONE) every file out of an already existing volume, so the
+   new volume starts out as a cheap, copy-on-write fork of the
+   source - growth past that point is still bounded by the new
+   volume's own quota.
+
+   Examples:
+
+     1. fork 'myvol' into 'myvol-fork', capped at 20M:
+
+            xfsvolctl clone \
+                --root /mnt/xfs \
+                --from myvol \
+                --name myvol-fork \
+                --size 20M
+	`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "root, r",
+			Usage: "Root of the volume listing",
+		},
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "Name of the existing volume to clone from",
+		},
+		cli.StringFlag{
+			Name:  "name, n",
+			Usage: "Name of the volume to create",
+		},
+		cli.StringFlag{
+			Name:  "size, s",
+			Usage: "Quota to assign to the new volume",
+		},
+		cli.StringFlag{
+			Name:  "fallback",
+			Usage: "What to do when reflink isn't possible ('' to fail, or 'copy')",
+		},
+	},
+	Action: cloneAction,
+}
+
+func cloneAction(c *cli.Context) (err error) {
+	var (
+		root     = c.String("root")
+		from     = c.String("from")
+		name     = c.String("name")
+		size     = c.String("size")
+		fallback = c.String("fallback")
+	)
+
+	if root == "" || from == "" || name == "" || size == "" {
+		cli.ShowCommandHelp(c, "clone")
+		err = cli.NewExitError("All parameters must be set", 1)
+		return
+	}
+
+	var cloneFallback manager.CloneFallback
+	switch fallback {
+	case "":
+		cloneFallback = manager.CloneFallbackNone
+	case "copy":
+		cloneFallback = manager.CloneFallbackCopy
+	default:
+		err = cli.NewExitError(errors.Errorf(
+			"unknown --fallback %q, expected '' or 'copy'", fallback), 1)
+		return
+	}
+
+	mgr, err := manager.New(manager.Config{
+		Root: root,
+	})
+	if err != nil {
+		err = cli.NewExitError(errors.Wrapf(err,
+			"Couldn't initiate manager"), 1)
+		return
+	}
+
+	sizeInBytes, err := manager.FromHumanSize(size)
+	if err != nil {
+		err = cli.NewExitError(errors.Wrapf(err,
+			"Couldn't convert specified size [%s] into bytes", size), 1)
+		return
+	}
+
+	absPath, err := mgr.Clone(from, name, sizeInBytes, cloneFallback)
+	if err != nil {
+		err = cli.NewExitError(errors.Wrapf(err,
+			"Couldn't clone volume %s into %s", from, name), 1)
+		return
+	}
+
+	fmt.Println(absPath)
+	return
+}
@@ -33,8 +33,8 @@ var Ls = cli.Command{
             xfsvolctl ls \
                 --root /mnt/xfs
 
-            NAME      QUOTA
-            myvol     10M
+            NAME      FS     BLK-QUOTA     BLK-USED     INODE-QUOTA     INODE-USED
+            myvol     xfs    10M           0B           0               1
 	`,
 	Flags: []cli.Flag{
 		cli.StringFlag{
@@ -74,13 +74,17 @@ func lsAction(c *cli.Context) (err error) {
 
 	w := new(tabwriter.Writer)
 	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
-	fmt.Fprintln(w, "NAME\tBLK-QUOTA\tINODE-QUOTA\t")
+	fmt.Fprintln(w, "NAME\tFS\tBLK-QUOTA\tBLK-USED\tINODE-QUOTA\tINODE-USED\t")
 
+	fs := mgr.GetFilesystem()
 	for _, vol := range vols {
-		fmt.Fprintf(w, "%s\t%s\t%d\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n",
 			vol.Name,
+			fs,
 			manager.HumanSize(vol.Size),
-			vol.INode)
+			manager.HumanSize(vol.Status["size_bytes_used"].(uint64)),
+			vol.INode,
+			vol.Status["inodes_used"])
 	}
 	w.Flush()
 	return
@@ -3,7 +3,7 @@ package main
 import (
 	"os"
 
-	"github.com/cirocosta/xfsvol/xfsvolctl/commands"
+	"github.com/auto1-oss/xfsvol/xfsvolctl/commands"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -18,8 +18,7 @@ func main() {
 	app.Usage = "Controls the 'xfsvol' volume plugin"
 	app.Commands = []cli.Command{
 		commands.Ls,
-		commands.Create,
-		commands.Delete,
+		commands.Clone,
 	}
 	app.Run(os.Args)
 }